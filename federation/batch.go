@@ -0,0 +1,244 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/samsarahq/thunder/graphql"
+)
+
+// BatchOptions tunes the key-lookup coalescer used by Executor.execute.
+type BatchOptions struct {
+	// MaxBatch caps how many distinct keys are sent in a single batched
+	// request. Zero means unbounded.
+	MaxBatch int
+	// Wait is how long the coalescer holds a batch open for more
+	// waiters to join before flushing it, counted from the batch's
+	// first waiter. Zero uses a 1ms debounce.
+	Wait time.Duration
+}
+
+type batchKey struct {
+	service string
+	typ     string
+}
+
+type batchWaiter struct {
+	key    interface{}
+	result chan batchResult
+}
+
+type batchResult struct {
+	value interface{}
+	err   error
+}
+
+// pendingBatch accumulates the keys and waiters for one (service, type)
+// pair until it is flushed by fetch.
+type pendingBatch struct {
+	mu      sync.Mutex
+	keys    []interface{}
+	seen    map[string]int // hash of a key's JSON encoding -> index into keys
+	waiters []batchWaiter
+	flushed bool
+	timer   *time.Timer
+}
+
+// keyBatcher coalesces __federation key lookups issued against the same
+// service and type within a single Executor.Execute (or Subscribe) call,
+// across every nested execute invocation it makes: callers
+// that would otherwise make their own round-trip instead join a shared
+// pendingBatch, and the batcher makes one call per (service, type) that
+// scatters results back to each waiter by key.
+type keyBatcher struct {
+	opts BatchOptions
+
+	mu      sync.Mutex
+	batches map[batchKey]*pendingBatch
+}
+
+func newKeyBatcher(opts BatchOptions) *keyBatcher {
+	return &keyBatcher{
+		opts:    opts,
+		batches: make(map[batchKey]*pendingBatch),
+	}
+}
+
+func hashKey(key interface{}) (string, error) {
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("hashing key: %v", err)
+	}
+	sum := sha256.Sum256(encoded)
+	return string(sum[:]), nil
+}
+
+// fetch enqueues key into the pending batch for (service, typ) and blocks
+// until that batch is flushed via fn, which receives the deduplicated
+// union of every waiter's key and must return one result per input key,
+// in the same order.
+func (b *keyBatcher) fetch(ctx context.Context, service, typ string, key interface{}, fn func(keys []interface{}) ([]interface{}, error)) (interface{}, error) {
+	hash, err := hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	bk := batchKey{service: service, typ: typ}
+
+	for {
+		b.mu.Lock()
+		pb, ok := b.batches[bk]
+		if !ok {
+			pb = &pendingBatch{seen: make(map[string]int)}
+			b.batches[bk] = pb
+		}
+		b.mu.Unlock()
+
+		pb.mu.Lock()
+		if pb.flushed {
+			// Lost the race against flush(): it already drained pb's
+			// waiters and removed pb from b.batches between our lookup
+			// above and this lock, so appending here would enqueue us
+			// behind a batch that will never flush again. Retry against
+			// whatever batch (new or concurrently recreated) is current.
+			pb.mu.Unlock()
+			continue
+		}
+
+		idx, ok := pb.seen[hash]
+		if !ok {
+			idx = len(pb.keys)
+			pb.seen[hash] = idx
+			pb.keys = append(pb.keys, key)
+		}
+
+		waiter := batchWaiter{key: key, result: make(chan batchResult, 1)}
+		pb.waiters = append(pb.waiters, waiter)
+
+		shouldFlush := b.opts.MaxBatch > 0 && len(pb.keys) >= b.opts.MaxBatch
+		if shouldFlush {
+			pb.flushed = true
+		} else if pb.timer == nil {
+			pb.timer = time.AfterFunc(b.waitFor(), func() {
+				b.flush(bk, fn)
+			})
+		}
+		pb.mu.Unlock()
+
+		if shouldFlush {
+			b.flush(bk, fn)
+		}
+
+		select {
+		case res := <-waiter.result:
+			return res.value, res.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *keyBatcher) waitFor() time.Duration {
+	if b.opts.Wait > 0 {
+		return b.opts.Wait
+	}
+	return time.Millisecond
+}
+
+func (b *keyBatcher) flush(bk batchKey, fn func(keys []interface{}) ([]interface{}, error)) {
+	b.mu.Lock()
+	pb := b.batches[bk]
+	delete(b.batches, bk)
+	b.mu.Unlock()
+
+	if pb == nil {
+		return
+	}
+
+	pb.mu.Lock()
+	pb.flushed = true
+	if pb.timer != nil {
+		pb.timer.Stop()
+	}
+	keys := pb.keys
+	seen := pb.seen
+	waiters := pb.waiters
+	pb.mu.Unlock()
+
+	results, err := fn(keys)
+
+	for _, w := range waiters {
+		hash, hashErr := hashKey(w.key)
+		if hashErr != nil {
+			w.result <- batchResult{err: hashErr}
+			continue
+		}
+
+		if err != nil {
+			w.result <- batchResult{err: err}
+			continue
+		}
+
+		idx := seen[hash]
+		if idx >= len(results) {
+			w.result <- batchResult{err: fmt.Errorf("batched fetch for %s.%s returned %d results for %d keys", bk.service, bk.typ, len(results), len(keys))}
+			continue
+		}
+
+		w.result <- batchResult{value: results[idx]}
+	}
+}
+
+// fetchKeysBatched looks up each of keys for subPlan through batcher,
+// sharing one upstream call across every sub-plan that targets the same
+// (subPlan.Service, subPlan.Type) and happens to be in flight at the
+// same time. The per-batch fetch itself is an ordinary e.execute call
+// against subPlan with the merged key list; any *graphql.Error the batch
+// produces is returned alongside the results rather than dropped, so a
+// batched sub-plan's failures surface in Response.Errors the same way an
+// unbatched one's do.
+func (e *Executor) fetchKeysBatched(ctx context.Context, batcher *keyBatcher, subPlan *Plan, keys []interface{}, path []PathStep) ([]interface{}, []*graphql.Error, error) {
+	out := make([]interface{}, len(keys))
+	errs := make([]error, len(keys))
+
+	var graphqlErrsMu sync.Mutex
+	var graphqlErrs []*graphql.Error
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		i, key := i, key
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			value, err := batcher.fetch(ctx, subPlan.Service, subPlan.Type, key, func(batchKeys []interface{}) ([]interface{}, error) {
+				results, subErrs, err := e.execute(ctx, subPlan, batchKeys, false, path)
+				if err != nil {
+					return nil, err
+				}
+				if len(subErrs) > 0 {
+					graphqlErrsMu.Lock()
+					graphqlErrs = append(graphqlErrs, subErrs...)
+					graphqlErrsMu.Unlock()
+				}
+				return results, nil
+			})
+
+			out[i] = value
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return out, graphqlErrs, nil
+}
@@ -0,0 +1,64 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestKeyBatcherFetchConcurrent hammers a single (service, type) batch
+// with many concurrent fetches while a short Wait is configured, so the
+// debounce timer and late-arriving waiters race each other the way
+// production traffic does. Every fetch must eventually return — a waiter
+// that got appended to a batch which had already flushed would otherwise
+// block forever, since a flushed pendingBatch never flushes again.
+func TestKeyBatcherFetchConcurrent(t *testing.T) {
+	b := newKeyBatcher(BatchOptions{Wait: time.Millisecond})
+
+	fn := func(keys []interface{}) ([]interface{}, error) {
+		out := make([]interface{}, len(keys))
+		for i, k := range keys {
+			out[i] = fmt.Sprintf("result-%v", k)
+		}
+		return out, nil
+	}
+
+	const n = 200
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	values := make([]interface{}, n)
+
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			values[i], errs[i] = b.fetch(ctx, "svc", "User", i, fn)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch calls did not all return: a late waiter likely hung on an already-flushed batch")
+	}
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("fetch(%d): %v", i, errs[i])
+		}
+		if want := fmt.Sprintf("result-%v", i); values[i] != want {
+			t.Errorf("fetch(%d) = %v, want %v", i, values[i], want)
+		}
+	}
+}
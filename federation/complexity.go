@@ -0,0 +1,162 @@
+package federation
+
+import (
+	"fmt"
+
+	"github.com/samsarahq/thunder/graphql"
+)
+
+// ComplexityFn estimates the cost of resolving a single field, given the
+// arguments the query passed it. It is only consulted for fields that
+// have been registered with SetFieldComplexity; every other field falls
+// back to defaultFieldComplexity.
+type ComplexityFn func(args map[string]interface{}, childComplexity int64) int64
+
+// complexityRegistry holds the per-(type, field) ComplexityFns a schema
+// has registered, plus the budget Executor.Execute enforces against them.
+type complexityRegistry struct {
+	fns map[string]map[string]ComplexityFn
+	// ComplexityLimit caps the additive cost of a query and everything
+	// it fans out into across the federated tree. Zero means no limit.
+	ComplexityLimit int64
+}
+
+// SetFieldComplexity registers fn as the cost function for typeName.fieldName.
+// Later calls for the same (typeName, fieldName) replace the previous fn.
+func (r *complexityRegistry) SetFieldComplexity(typeName, fieldName string, fn ComplexityFn) {
+	if r.fns == nil {
+		r.fns = make(map[string]map[string]ComplexityFn)
+	}
+	if r.fns[typeName] == nil {
+		r.fns[typeName] = make(map[string]ComplexityFn)
+	}
+	r.fns[typeName][fieldName] = fn
+}
+
+func (r *complexityRegistry) fieldComplexity(typeName, fieldName string, args map[string]interface{}, childComplexity int64) int64 {
+	if fn, ok := r.fns[typeName][fieldName]; ok {
+		return fn(args, childComplexity)
+	}
+	return defaultFieldComplexity(args, childComplexity)
+}
+
+// defaultFieldComplexity costs a field at 1 plus its children, multiplied
+// by any "first" or "limit" argument the field was called with — the
+// same heuristic gqlgen applies for list-returning fields.
+func defaultFieldComplexity(args map[string]interface{}, childComplexity int64) int64 {
+	multiplier := int64(1)
+	for _, name := range []string{"first", "limit"} {
+		if v, ok := args[name]; ok {
+			if n, ok := toInt64(v); ok && n > 0 {
+				multiplier = n
+			}
+		}
+	}
+
+	return multiplier * (1 + childComplexity)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// complexityExceededError is returned when a query's estimated cost is
+// over the configured ComplexityLimit. It is surfaced before any
+// upstream call is made.
+type complexityExceededError struct {
+	Cost  int64
+	Limit int64
+}
+
+func (e *complexityExceededError) Error() string {
+	return fmt.Sprintf("query complexity %d exceeds limit %d", e.Cost, e.Limit)
+}
+
+// resolveFieldType looks up the named return type of typeName.fieldName
+// against the merged introspection schemas gathered from every federated
+// service, unwrapping any NON_NULL/LIST wrapper types down to the
+// leaf named type. It returns "" if the type or field can't be found.
+func (e *Executor) resolveFieldType(typeName, fieldName string) string {
+	e.schemasMu.Lock()
+	schemas := e.lastSchemas
+	e.schemasMu.Unlock()
+
+	for _, schema := range schemas {
+		if schema == nil || schema.Schema == nil {
+			continue
+		}
+		for _, t := range schema.Schema.Types {
+			if t.Name != typeName {
+				continue
+			}
+			for _, f := range t.Fields {
+				if f.Name == fieldName {
+					return namedTypeOf(f.Type)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func namedTypeOf(t *introspectionTypeRef) string {
+	for t != nil {
+		if t.Name != "" {
+			return t.Name
+		}
+		t = t.OfType
+	}
+	return ""
+}
+
+// fieldTypeResolver returns the name of the GraphQL type that
+// typeName.fieldName resolves to, so the complexity estimator can look
+// up overrides for fields more than one level deep. An empty result
+// means the type couldn't be resolved (e.g. an introspection field);
+// callers should treat that like an unregistered field.
+type fieldTypeResolver func(typeName, fieldName string) string
+
+// estimateComplexity walks p and its After sub-plans, summing the cost
+// of every selected field. Costs are additive across the federated tree,
+// so a root field that fans out into several __federation lookups has
+// those lookups' costs folded into the total.
+func estimateComplexity(registry *complexityRegistry, resolveType fieldTypeResolver, p *Plan) int64 {
+	if registry == nil || p == nil {
+		return 0
+	}
+
+	var total int64
+	for _, subPlan := range p.After {
+		total += estimateComplexity(registry, resolveType, subPlan)
+	}
+
+	total += estimateSelectionComplexity(registry, resolveType, p.Type, p.SelectionSet)
+
+	return total
+}
+
+func estimateSelectionComplexity(registry *complexityRegistry, resolveType fieldTypeResolver, typeName string, selectionSet *graphql.SelectionSet) int64 {
+	if selectionSet == nil {
+		return 0
+	}
+
+	var total int64
+	for _, selection := range selectionSet.Selections {
+		childType := resolveType(typeName, selection.Name)
+		child := estimateSelectionComplexity(registry, resolveType, childType, selection.SelectionSet)
+		total += registry.fieldComplexity(typeName, selection.Name, selection.Args, child)
+	}
+
+	return total
+}
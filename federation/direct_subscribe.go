@@ -0,0 +1,46 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samsarahq/thunder/graphql"
+	"github.com/samsarahq/thunder/graphql/schemabuilder"
+	"github.com/samsarahq/thunder/reactive"
+)
+
+// Subscribe implements ExecutorClient.Subscribe for in-process federated
+// services. It reuses schemabuilder's existing live-query plumbing: a
+// reactive.Rerunner re-executes the query whenever a dependency
+// invalidates, and each successful rerun is marshaled and pushed onto the
+// returned channel.
+func (c *DirectExecutorClient) Subscribe(ctx context.Context, req *graphql.Query) (<-chan []byte, error) {
+	out := make(chan []byte)
+
+	rerunner := reactive.NewRerunner(ctx, func(ctx context.Context) (interface{}, error) {
+		result, err := c.Client.schema.Query.Execute(ctx, req.SelectionSet)
+		if err != nil {
+			return nil, fmt.Errorf("executing subscription: %v", err)
+		}
+
+		marshaled, err := schemabuilder.MarshalResponse(result)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling subscription result: %v", err)
+		}
+
+		select {
+		case out <- marshaled:
+		case <-ctx.Done():
+		}
+
+		return nil, nil
+	}, 0, false)
+
+	go func() {
+		<-ctx.Done()
+		rerunner.Stop()
+		close(out)
+	}()
+
+	return out, nil
+}
@@ -5,14 +5,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/samsarahq/thunder/graphql"
 	"github.com/samsarahq/thunder/graphql/introspection"
-	"golang.org/x/sync/errgroup"
 )
 
 type ExecutorClient interface {
 	Execute(ctx context.Context, req *graphql.Query) ([]byte, error)
+
+	// Subscribe starts a subscription operation against the upstream
+	// service. The returned channel is sent one marshaled payload per
+	// upstream event and is closed when the subscription ends, either
+	// because ctx was canceled or the upstream closed the stream.
+	Subscribe(ctx context.Context, req *graphql.Query) (<-chan []byte, error)
 }
 
 // Executor has a map of all the executor clients such that it can execute a
@@ -20,7 +26,80 @@ type ExecutorClient interface {
 // The planner allows it to coordinate the subqueries being sent to the federated servers
 type Executor struct {
 	Executors map[string]ExecutorClient
-	planner   *Planner
+
+	// plannerValue holds the current rootPlanner (in production, always a
+	// *Planner) behind an atomic.Value so ReloadSchemas can swap in a
+	// planner built from a fresh schema without disturbing an Execute
+	// call that is already under way.
+	plannerValue atomic.Value
+
+	complexity complexityRegistry
+
+	// Batch configures the DataLoader-style coalescer that execute uses
+	// to merge __federation key lookups against the same service and
+	// type into shared round-trips within one Execute call. A batch is
+	// flushed when its Wait elapses or it reaches MaxBatch keys, so one
+	// Execute call can still make several trips per (service, type).
+	Batch BatchOptions
+
+	// OnSchemaChange, if set, is called after ReloadSchemas picks up a
+	// change in any upstream's schema, once the new planner is live.
+	OnSchemaChange func(diff SchemaDiff)
+
+	schemasMu   sync.Mutex
+	lastSchemas map[string]*introspectionQueryResult
+
+	// reloading single-flights ReloadSchemas across every caller, manual
+	// or watcher-triggered; see ReloadSchemas for why.
+	reloading int32
+}
+
+// rootPlanner is the planning surface Execute and Subscribe depend on.
+// *Planner is its only production implementation; the interface exists
+// so tests can drive Execute/Subscribe end to end against a fake planner
+// without needing a real merged schema, the same way ExecutorClient lets
+// tests fake an upstream service.
+type rootPlanner interface {
+	planRoot(q *graphql.Query) (*Plan, error)
+	planSubscriptionRoot(q *graphql.Query) (*Plan, error)
+}
+
+func (e *Executor) planner() rootPlanner {
+	return e.plannerValue.Load().(rootPlanner)
+}
+
+func (e *Executor) setPlanner(p rootPlanner) {
+	e.plannerValue.Store(p)
+}
+
+type batcherContextKey struct{}
+
+// withBatcher attaches a fresh keyBatcher to ctx, scoped to a single
+// Execute (or Subscribe) call: every keyed lookup for the same service
+// and type made while walking that call's plan tree shares one batcher,
+// so siblings coalesce but unrelated requests never do.
+func (e *Executor) withBatcher(ctx context.Context) context.Context {
+	return context.WithValue(ctx, batcherContextKey{}, newKeyBatcher(e.Batch))
+}
+
+func batcherFromContext(ctx context.Context) *keyBatcher {
+	b, _ := ctx.Value(batcherContextKey{}).(*keyBatcher)
+	return b
+}
+
+// SetFieldComplexity registers a custom cost function for typeName.fieldName,
+// overriding defaultFieldComplexity for that field when Execute estimates
+// a query's cost against ComplexityLimit.
+func (e *Executor) SetFieldComplexity(typeName, fieldName string, fn ComplexityFn) {
+	e.complexity.SetFieldComplexity(typeName, fieldName, fn)
+}
+
+// SetComplexityLimit caps the additive estimated cost of a query and
+// everything it fans out into across the federated tree. Execute rejects
+// queries over the limit before making any upstream call. A limit of
+// zero, the default, disables enforcement.
+func (e *Executor) SetComplexityLimit(limit int64) {
+	e.complexity.ComplexityLimit = limit
 }
 
 func fetchSchema(ctx context.Context, e ExecutorClient) ([]byte, error) {
@@ -32,18 +111,22 @@ func fetchSchema(ctx context.Context, e ExecutorClient) ([]byte, error) {
 	return e.Execute(ctx, query)
 }
 
-func NewExecutor(ctx context.Context, executors map[string]ExecutorClient) (*Executor, error) {
+// buildPlanner re-fetches introspection from every executor, converts
+// the merged result into a schema and flattener, and returns the planner
+// for it. Both NewExecutor and ReloadSchemas go through this so startup
+// and hot-reload build the exact same planner.
+func buildPlanner(ctx context.Context, executors map[string]ExecutorClient) (*Planner, map[string]*introspectionQueryResult, error) {
 	// Fetches the schemas from the executors clients
 	schemas := make(map[string]*introspectionQueryResult)
 	for server, client := range executors {
 		schema, err := fetchSchema(ctx, client)
 		if err != nil {
-			return nil, fmt.Errorf("fetching schema %s: %v", server, err)
+			return nil, nil, fmt.Errorf("fetching schema %s: %v", server, err)
 		}
 
 		var iq introspectionQueryResult
 		if err := json.Unmarshal(schema, &iq); err != nil {
-			return nil, fmt.Errorf("unmarshaling schema %s: %v", server, err)
+			return nil, nil, fmt.Errorf("unmarshaling schema %s: %v", server, err)
 		}
 
 		schemas[server] = &iq
@@ -51,7 +134,7 @@ func NewExecutor(ctx context.Context, executors map[string]ExecutorClient) (*Exe
 
 	types, err := convertSchema(schemas)
 	if err != nil {
-		return nil, fmt.Errorf("converting schema error: %v", err)
+		return nil, nil, fmt.Errorf("converting schema error: %v", err)
 	}
 
 	introspectionSchema := introspection.BareIntrospectionSchema(types.Schema)
@@ -62,18 +145,18 @@ func NewExecutor(ctx context.Context, executors map[string]ExecutorClient) (*Exe
 
 	var iq introspectionQueryResult
 	if err := json.Unmarshal(schema, &iq); err != nil {
-		return nil, fmt.Errorf("unmarshaling introspection schema: %v", err)
+		return nil, nil, fmt.Errorf("unmarshaling introspection schema: %v", err)
 	}
 
 	schemas["introspection"] = &iq
 	types, err = convertSchema(schemas)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	flattener, err := newFlattener(types.Schema)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// The planner is aware of the merged schema and what executors
@@ -83,11 +166,22 @@ func NewExecutor(ctx context.Context, executors map[string]ExecutorClient) (*Exe
 		flattener: flattener,
 	}
 
-	return &Executor{
+	return planner, schemas, nil
+}
+
+func NewExecutor(ctx context.Context, executors map[string]ExecutorClient) (*Executor, error) {
+	planner, schemas, err := buildPlanner(ctx, executors)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Executor{
 		Executors: executors,
-		planner:   planner,
-	}, nil
+	}
+	e.setPlanner(planner)
+	e.lastSchemas = schemas
 
+	return e, nil
 }
 
 type pathFollower struct {
@@ -152,73 +246,433 @@ func (pf *pathFollower) extractTargets(node interface{}, path []PathStep) error
 	return nil
 }
 
-func (e *Executor) execute(ctx context.Context, p *Plan, keys []interface{}) ([]interface{}, error) {
+// fetchFromService dispatches p's own selection set to its upstream
+// service and decodes the response. keys, when non-empty, are merged
+// into the query's "keys" variable so a federation key lookup asks for
+// every key in one round-trip; the planner is responsible for shaping
+// p.Query's selection set to accept it.
+//
+// Errors the upstream itself reports are returned alongside the decoded
+// data rather than folded into err, so a partial failure inside one
+// federated service surfaces through Response.Errors the same way a
+// gateway-side failure does, instead of aborting the whole plan.
+func (e *Executor) fetchFromService(ctx context.Context, p *Plan, keys []interface{}, path []PathStep) ([]interface{}, []*graphql.Error, error) {
+	client, ok := e.Executors[p.Service]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown service %s", p.Service)
+	}
+
+	query := p.Query
+	if len(keys) > 0 {
+		vars := make(map[string]interface{}, len(query.Variables)+1)
+		for k, v := range query.Variables {
+			vars[k] = v
+		}
+		vars["keys"] = keys
+
+		cloned := *query
+		cloned.Variables = vars
+		query = &cloned
+	}
+
+	raw, err := client.Execute(ctx, query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("executing against %s: %v", p.Service, err)
+	}
+
+	var decoded struct {
+		Data   []interface{}    `json:"data"`
+		Errors []*graphql.Error `json:"errors"`
+	}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, nil, fmt.Errorf("decoding response from %s: %v", p.Service, err)
+	}
+
+	for _, ge := range decoded.Errors {
+		ge.Path = append(pathStrings(path), ge.Path...)
+	}
+
+	return decoded.Data, decoded.Errors, nil
+}
+
+// execute runs p and its sub-plans, merging each sub-plan's result back
+// into the parent object(s) it targets. Root-level selections of a
+// mutation must be applied strictly in order per the GraphQL spec, so
+// sequential forces p.After to run one at a time instead of concurrently;
+// nested plans underneath a single mutation field are unaffected and
+// keep running concurrently, since only the caller at the mutation root
+// passes sequential=true.
+//
+// A failure in one sub-plan does not abort its siblings: it is recorded
+// as a *graphql.Error tagged with the PathStep trail to that sub-plan
+// and the sub-plan's fields are set to null on the targets it would
+// have filled (see nullSubPlanFields). The returned error is reserved
+// for a failure of p's own fetch (unknown service, transport or
+// decoding error), which leaves nothing to merge sub-plans into.
+func (e *Executor) execute(ctx context.Context, p *Plan, keys []interface{}, sequential bool, path []PathStep) ([]interface{}, []*graphql.Error, error) {
 	var res []interface{}
+	var errs []*graphql.Error
 
 	if p.Service == gatewayCoordinatorServiceName {
 		res = []interface{}{
 			map[string]interface{}{},
 		}
+	} else {
+		fetched, upstreamErrs, err := e.fetchFromService(ctx, p, keys, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		res = fetched
+		errs = upstreamErrs
 	}
 
-	g, ctx := errgroup.WithContext(ctx)
+	var errsMu sync.Mutex
+	var nonNullFailure bool
+
+	batcher := batcherFromContext(ctx)
+
+	// nullSubPlanFields explicitly sets every field subPlan would have
+	// populated to nil on each of pf.targets, rather than leaving the
+	// keys unset, so GraphQL clients see an explicit null instead of
+	// having to infer one from a missing key. If any of those fields is
+	// non-nullable, it also flags nonNullFailure so the caller nulls out
+	// the nearest nullable ancestor (res[0] of this execute call) per
+	// the GraphQL error-propagation rules.
+	nullSubPlanFields := func(subPlan *Plan, pf *pathFollower) {
+		if subPlan.SelectionSet == nil {
+			return
+		}
+		for _, selection := range subPlan.SelectionSet.Selections {
+			for _, target := range pf.targets {
+				target[selection.Name] = nil
+			}
+			if !e.fieldNullable(subPlan.Type, selection.Name) {
+				nonNullFailure = true
+			}
+		}
+	}
 
-	var resMu sync.Mutex
+	addError := func(message string, path []PathStep, extensions map[string]interface{}) {
+		errsMu.Lock()
+		errs = append(errs, &graphql.Error{
+			Message:    message,
+			Path:       pathStrings(path),
+			Extensions: extensions,
+		})
+		errsMu.Unlock()
+	}
 
-	for _, subPlan := range p.After {
-		subPlan := subPlan
+	runSubPlan := func(subPlan *Plan) {
 		var pf pathFollower
-		pf.keys = nil
-		pf.targets = []map[string]interface{}{
-			res[0].(map[string]interface{}),
+		subPath := append(append([]PathStep{}, path...), subPlan.Path...)
+
+		if len(subPlan.Path) == 0 {
+			// A root-level selection (a direct child of the gateway
+			// coordinator plan) merges straight into this plan's own
+			// result object; it doesn't cross a __federation boundary,
+			// so there's no key to extract and extractTargets' check
+			// for one doesn't apply.
+			if len(res) == 0 {
+				addError("result is empty: expected a root object", subPath, map[string]interface{}{
+					"code":    "RESULT_NOT_AN_OBJECT",
+					"service": subPlan.Service,
+				})
+				return
+			}
+			target, ok := res[0].(map[string]interface{})
+			if !ok {
+				addError(fmt.Sprintf("not an object: %v", res[0]), subPath, map[string]interface{}{
+					"code":    "EXTRACT_TARGETS_FAILED",
+					"service": subPlan.Service,
+				})
+				return
+			}
+			pf.targets = []map[string]interface{}{target}
+		} else if len(res) == 0 {
+			addError("result is empty: expected a root object", subPath, map[string]interface{}{
+				"code":    "RESULT_NOT_AN_OBJECT",
+				"service": subPlan.Service,
+			})
+			return
+		} else if err := pf.extractTargets(res[0], subPlan.Path); err != nil {
+			addError(fmt.Sprintf("extracting targets: %v", err), subPath, map[string]interface{}{
+				"code":    "EXTRACT_TARGETS_FAILED",
+				"service": subPlan.Service,
+			})
+			nullSubPlanFields(subPlan, &pf)
+			return
+		}
+
+		var results []interface{}
+		var err error
+
+		if batcher != nil && subPlan.Service != gatewayCoordinatorServiceName && len(pf.keys) > 0 {
+			// Coalesce this sub-plan's key lookup with any sibling
+			// sub-plan targeting the same (service, type): batcher.fetch
+			// buffers concurrent callers and issues one merged upstream
+			// call per batch instead of one per sub-plan. This assumes
+			// sibling sub-plans sharing a (service, type) request the
+			// same fields, which holds because the planner emits one
+			// sub-plan shape per (service, type) pair.
+			var subErrs []*graphql.Error
+			results, subErrs, err = e.fetchKeysBatched(ctx, batcher, subPlan, pf.keys, subPath)
+
+			errsMu.Lock()
+			errs = append(errs, subErrs...)
+			errsMu.Unlock()
+		} else {
+			var subErrs []*graphql.Error
+			results, subErrs, err = e.execute(ctx, subPlan, pf.keys, false, subPath)
+
+			errsMu.Lock()
+			errs = append(errs, subErrs...)
+			errsMu.Unlock()
 		}
 
-		g.Go(func() error {
-			results, err := e.execute(ctx, subPlan, pf.keys)
-			if err != nil {
-				return fmt.Errorf("executing sub plan: %v", err)
+		if err != nil {
+			addError(fmt.Sprintf("executing sub plan: %v", err), subPath, map[string]interface{}{
+				"code":    "SUB_PLAN_FAILED",
+				"service": subPlan.Service,
+			})
+			nullSubPlanFields(subPlan, &pf)
+			return
+		}
+
+		if len(results) != len(pf.targets) {
+			addError(fmt.Sprintf("got %d results for %d targets", len(results), len(pf.targets)), subPath, map[string]interface{}{
+				"code":    "RESULT_SHAPE_MISMATCH",
+				"service": subPlan.Service,
+			})
+			nullSubPlanFields(subPlan, &pf)
+			return
+		}
+
+		for i, target := range pf.targets {
+			if results[i] == nil {
+				// The child execute call already recorded its own error
+				// and nulled its own root because a non-null failure
+				// bubbled up to it; propagate that null here without
+				// stacking a second, less-specific error on top of the
+				// one it already added.
+				nullSubPlanFields(subPlan, &pathFollower{targets: []map[string]interface{}{target}})
+				continue
 			}
 
-			if len(results) != len(pf.targets) {
-				return fmt.Errorf("got %d results for %d targets", len(results), len(pf.targets))
+			result, ok := results[i].(map[string]interface{})
+			if !ok {
+				addError(fmt.Sprintf("result is not an object: %v", result), subPath, map[string]interface{}{
+					"code":    "RESULT_NOT_AN_OBJECT",
+					"service": subPlan.Service,
+				})
+				nullSubPlanFields(subPlan, &pathFollower{targets: []map[string]interface{}{target}})
+				continue
+			}
+			for k, v := range result {
+				target[k] = v
 			}
+		}
+	}
+
+	if sequential {
+		for _, subPlan := range p.After {
+			runSubPlan(subPlan)
+		}
+		return nullOnNonNullFailure(res, nonNullFailure), errs, nil
+	}
+
+	var wg sync.WaitGroup
+	for _, subPlan := range p.After {
+		subPlan := subPlan
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runSubPlan(subPlan)
+		}()
+	}
+	wg.Wait()
 
-			resMu.Lock()
-			defer resMu.Unlock()
+	return nullOnNonNullFailure(res, nonNullFailure), errs, nil
+}
+
+// Subscribe plans and executes a subscription operation. Unlike Execute,
+// the root selection of a subscription must live entirely on a single
+// upstream service, so planSubscriptionRoot pins p to that service's own
+// plan rather than the gateway coordinator. Each event the upstream emits
+// is fanned through p.After (ordinary, non-streaming sub-plans) before
+// the merged payload is delivered on the returned channel.
+func (e *Executor) Subscribe(ctx context.Context, q *graphql.Query) (<-chan interface{}, error) {
+	p, err := e.planner().planSubscriptionRoot(q)
+	if err != nil {
+		return nil, err
+	}
+
+	printPlan(p)
 
-			for i, target := range pf.targets {
-				result, ok := results[i].(map[string]interface{})
+	ctx = e.withBatcher(ctx)
+
+	client, ok := e.Executors[p.Service]
+	if !ok {
+		return nil, fmt.Errorf("subscribing: unknown service %s", p.Service)
+	}
+
+	upstream, err := client.Subscribe(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to %s: %v", p.Service, err)
+	}
+
+	out := make(chan interface{})
+
+	go func() {
+		defer close(out)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case raw, ok := <-upstream:
 				if !ok {
-					return fmt.Errorf("result is not an object: %v", result)
+					return
+				}
+
+				var event map[string]interface{}
+				if err := json.Unmarshal(raw, &event); err != nil {
+					return
+				}
+
+				if err := e.mergeSubscriptionEvent(ctx, p.After, event); err != nil {
+					return
 				}
-				for k, v := range result {
-					target[k] = v
+
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
 				}
 			}
+		}
+	}()
 
-			return nil
-		})
-	}
+	return out, nil
+}
 
-	if err := g.Wait(); err != nil {
-		return nil, err
+// mergeSubscriptionEvent fans one upstream subscription event through
+// after, the plans Subscribe resolved the root field's dependent
+// selections into, merging each sub-plan's result back into event in
+// place. It mirrors runSubPlan's merge step but works against a single,
+// already-known target instead of extracting targets via a pathFollower,
+// since a subscription event is always one object, not a __federation-keyed
+// collection.
+func (e *Executor) mergeSubscriptionEvent(ctx context.Context, after []*Plan, event map[string]interface{}) error {
+	for _, subPlan := range after {
+		var pf pathFollower
+		pf.targets = []map[string]interface{}{event}
+
+		results, _, err := e.execute(ctx, subPlan, pf.keys, false, nil)
+		if err != nil {
+			return err
+		}
+
+		if len(results) != len(pf.targets) {
+			return fmt.Errorf("got %d results for %d targets", len(results), len(pf.targets))
+		}
+
+		for i, target := range pf.targets {
+			result, ok := results[i].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("result is not an object: %v", result)
+			}
+			for k, v := range result {
+				target[k] = v
+			}
+		}
 	}
-	return res, nil
+
+	return nil
+}
+
+// Response is the result of a federated Execute call. Errors holds
+// GraphQL-shaped errors for any sub-plan that failed; Data still carries
+// whatever fields the surviving sub-plans were able to fill in, per the
+// GraphQL error-propagation rules for partial results.
+type Response struct {
+	Data   interface{}
+	Errors []*graphql.Error
 }
 
-func (e *Executor) Execute(ctx context.Context, q *graphql.Query) (interface{}, error) {
-	p, err := e.planner.planRoot(q)
+func (e *Executor) Execute(ctx context.Context, q *graphql.Query) (*Response, error) {
+	p, err := e.planner().planRoot(q)
 	if err != nil {
 		return nil, err
 	}
 
+	if limit := e.complexity.ComplexityLimit; limit > 0 {
+		if cost := estimateComplexity(&e.complexity, e.resolveFieldType, p); cost > limit {
+			return nil, &complexityExceededError{Cost: cost, Limit: limit}
+		}
+	}
+
 	printPlan(p)
-	r, err := e.execute(ctx, p, nil)
+	ctx = e.withBatcher(ctx)
+	r, errs, err := e.execute(ctx, p, nil, p.OperationType == graphql.OperationTypeMutation, nil)
 	if err != nil {
 		return nil, err
 	}
-	res := r[0]
 
-	return res, nil
+	return &Response{
+		Data:   r[0],
+		Errors: errs,
+	}, nil
+}
 
+// pathStrings renders a PathStep trail into the string path GraphQL
+// clients expect on an error's "path" field, e.g. ["user", "Admin", "name"].
+func pathStrings(path []PathStep) []interface{} {
+	out := make([]interface{}, 0, len(path))
+	for _, step := range path {
+		out = append(out, step.Name)
+	}
+	return out
+}
+
+// nullOnNonNullFailure nulls out every root object in res when a
+// non-nullable field somewhere under them failed to resolve, per the
+// GraphQL rule that a non-null error bubbles up to the nearest
+// nullable ancestor. res keeps its length, so a keyed lookup still
+// returns one (nil) result per target. res's own plan level is the
+// nearest ancestor this function tracks; bubbling further up the tree
+// is the caller's job, since its own non-null fields are checked the
+// same way one level up.
+func nullOnNonNullFailure(res []interface{}, failed bool) []interface{} {
+	if !failed || len(res) == 0 {
+		return res
+	}
+	return make([]interface{}, len(res))
+}
+
+// fieldNullable reports whether typeName.fieldName is allowed to
+// resolve to null, based on the merged introspection schemas. Unknown
+// fields are treated as nullable so a lookup miss degrades to leaving
+// the field as an ordinary null rather than nulling out its ancestor.
+func (e *Executor) fieldNullable(typeName, fieldName string) bool {
+	e.schemasMu.Lock()
+	schemas := e.lastSchemas
+	e.schemasMu.Unlock()
+
+	for _, schema := range schemas {
+		if schema == nil || schema.Schema == nil {
+			continue
+		}
+		for _, t := range schema.Schema.Types {
+			if t.Name != typeName {
+				continue
+			}
+			for _, f := range t.Fields {
+				if f.Name == fieldName {
+					return f.Type == nil || f.Type.Kind != "NON_NULL"
+				}
+			}
+		}
+	}
+	return true
 }
\ No newline at end of file
@@ -0,0 +1,274 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samsarahq/thunder/graphql"
+)
+
+// emptyDataClient is an ExecutorClient whose Execute always reports a
+// zero-length data array, the shape an upstream sends back for a root
+// selection with no rows rather than a single empty object.
+type emptyDataClient struct{}
+
+func (emptyDataClient) Execute(ctx context.Context, req *graphql.Query) ([]byte, error) {
+	return []byte(`{"data": []}`), nil
+}
+
+func (emptyDataClient) Subscribe(ctx context.Context, req *graphql.Query) (<-chan []byte, error) {
+	return nil, fmt.Errorf("emptyDataClient does not support subscriptions")
+}
+
+// fakeRootPlanner is a minimal rootPlanner for tests that need to drive
+// Execute or Subscribe through their public entry points without a real
+// merged schema: it always hands back the same preconfigured plan.
+type fakeRootPlanner struct {
+	plan *Plan
+	err  error
+}
+
+func (f *fakeRootPlanner) planRoot(q *graphql.Query) (*Plan, error) {
+	return f.plan, f.err
+}
+
+func (f *fakeRootPlanner) planSubscriptionRoot(q *graphql.Query) (*Plan, error) {
+	return f.plan, f.err
+}
+
+// fakeSubscribeClient is an ExecutorClient whose Subscribe streams a
+// fixed sequence of events, then blocks until ctx is canceled, the way a
+// long-lived upstream subscription would.
+type fakeSubscribeClient struct {
+	events []map[string]interface{}
+}
+
+func (c *fakeSubscribeClient) Execute(ctx context.Context, req *graphql.Query) ([]byte, error) {
+	return nil, fmt.Errorf("fakeSubscribeClient does not support Execute")
+}
+
+func (c *fakeSubscribeClient) Subscribe(ctx context.Context, req *graphql.Query) (<-chan []byte, error) {
+	out := make(chan []byte, len(c.events))
+	for _, event := range c.events {
+		marshaled, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		out <- marshaled
+	}
+
+	go func() {
+		<-ctx.Done()
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// TestSubscribeStreamsUpstreamEvents drives Executor.Subscribe end to
+// end through its public API: a fake rootPlanner pins the root to a
+// single service (mirroring what planSubscriptionRoot does for a real
+// coordinator root), and a fake ExecutorClient streams events for it.
+// Subscribe has no dependent sub-plans to merge here, so what this
+// checks is the plumbing around that: planning, dispatching to the
+// pinned service's Subscribe, and relaying each event in order until
+// ctx is canceled.
+func TestSubscribeStreamsUpstreamEvents(t *testing.T) {
+	e := &Executor{
+		Executors: map[string]ExecutorClient{
+			"svc-a": &fakeSubscribeClient{
+				events: []map[string]interface{}{
+					{"id": "1"},
+					{"id": "2"},
+				},
+			},
+		},
+	}
+	e.setPlanner(&fakeRootPlanner{plan: &Plan{Service: "svc-a"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := e.Subscribe(ctx, &graphql.Query{})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	for _, want := range []string{"1", "2"} {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				t.Fatalf("channel closed early, wanted event id %q", want)
+			}
+			got, _ := event.(map[string]interface{})
+			if got["id"] != want {
+				t.Errorf("got event %v, want id %q", event, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event id %q", want)
+		}
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected channel to close after ctx was canceled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel did not close after ctx was canceled")
+	}
+}
+
+// TestSubscribePropagatesPlanningError checks that a subscription whose
+// root can't be pinned to a single service (the case planSubscriptionRoot
+// itself rejects) surfaces as an error from Subscribe rather than a panic
+// or a silently empty stream.
+func TestSubscribePropagatesPlanningError(t *testing.T) {
+	e := &Executor{}
+	e.setPlanner(&fakeRootPlanner{err: fmt.Errorf("subscription root must resolve on a single upstream service, got 2")})
+
+	if _, err := e.Subscribe(context.Background(), &graphql.Query{}); err == nil {
+		t.Fatal("expected an error when planning the subscription root fails")
+	}
+}
+
+// TestExecuteMutationRootRunsSequentially exercises the ordering guarantee
+// a mutation's root selections get: runSubPlan must be called for each
+// entry in p.After strictly in selection-set order, so that a two-service
+// federated mutation observes its side effects in the order the client
+// wrote them. Neither sub-plan's service is registered on the Executor,
+// so execute reports a SUB_PLAN_FAILED error for each one; what the test
+// checks is the order those errors come back in, which mirrors the order
+// runSubPlan actually ran in.
+func TestExecuteMutationRootRunsSequentially(t *testing.T) {
+	root := &Plan{
+		Service:       gatewayCoordinatorServiceName,
+		OperationType: graphql.OperationTypeMutation,
+		After: []*Plan{
+			{Service: "svc-a"},
+			{Service: "svc-b"},
+		},
+	}
+
+	e := &Executor{}
+
+	_, errs, err := e.execute(context.Background(), root, nil, root.OperationType == graphql.OperationTypeMutation, nil)
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if len(errs) != len(root.After) {
+		t.Fatalf("got %d errors, want %d", len(errs), len(root.After))
+	}
+
+	for i, subPlan := range root.After {
+		service, _ := errs[i].Extensions["service"].(string)
+		if service != subPlan.Service {
+			t.Errorf("error %d: got service %q, want %q (selections ran out of order)", i, service, subPlan.Service)
+		}
+	}
+}
+
+// TestExecuteQueryRootRunsConcurrently drives a query root down the
+// non-sequential path (sequential=false) and checks that both sub-plans
+// still run: each reports its own unknown-service error, in either
+// order. It does not observe whether the two actually overlapped.
+func TestExecuteQueryRootRunsConcurrently(t *testing.T) {
+	root := &Plan{
+		Service:       gatewayCoordinatorServiceName,
+		OperationType: graphql.OperationTypeQuery,
+		After: []*Plan{
+			{Service: "svc-a"},
+			{Service: "svc-b"},
+		},
+	}
+
+	e := &Executor{}
+
+	_, errs, err := e.execute(context.Background(), root, nil, root.OperationType == graphql.OperationTypeMutation, nil)
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	seen := make(map[string]bool, len(errs))
+	for _, ge := range errs {
+		service, _ := ge.Extensions["service"].(string)
+		seen[service] = true
+	}
+	for _, subPlan := range root.After {
+		if !seen[subPlan.Service] {
+			t.Errorf("missing result for %s", subPlan.Service)
+		}
+	}
+}
+
+// TestExecuteRootLevelMergeHandlesEmptyResult checks that a root-level
+// sub-plan merge (subPlan.Path is empty) reports a graceful error instead
+// of panicking when the parent plan's own fetch comes back with zero
+// results, which a real upstream can legitimately return for an empty
+// result set.
+func TestExecuteRootLevelMergeHandlesEmptyResult(t *testing.T) {
+	root := &Plan{
+		Service: "svc-a",
+		Query:   &graphql.Query{},
+		After: []*Plan{
+			{Service: "svc-b"},
+		},
+	}
+
+	e := &Executor{
+		Executors: map[string]ExecutorClient{
+			"svc-a": emptyDataClient{},
+		},
+	}
+
+	_, errs, err := e.execute(context.Background(), root, nil, false, nil)
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1", len(errs))
+	}
+	if code, _ := errs[0].Extensions["code"].(string); code != "RESULT_NOT_AN_OBJECT" {
+		t.Errorf("got code %q, want RESULT_NOT_AN_OBJECT", code)
+	}
+}
+
+// TestMergeSubscriptionEventMergesCoordinatorSubPlan exercises the merge
+// step Subscribe runs per upstream event: a sub-plan that resolves
+// cleanly should leave the event object merged in place without error.
+func TestMergeSubscriptionEventMergesCoordinatorSubPlan(t *testing.T) {
+	e := &Executor{}
+	event := map[string]interface{}{"id": "1"}
+
+	after := []*Plan{
+		{Service: gatewayCoordinatorServiceName},
+	}
+
+	if err := e.mergeSubscriptionEvent(context.Background(), after, event); err != nil {
+		t.Fatalf("mergeSubscriptionEvent: %v", err)
+	}
+	if event["id"] != "1" {
+		t.Fatalf("event mutated unexpectedly: %v", event)
+	}
+}
+
+// TestMergeSubscriptionEventPropagatesSubPlanFailure checks that a
+// failing dependent sub-plan surfaces as an error instead of being
+// silently swallowed, so Subscribe's goroutine knows to stop the stream.
+func TestMergeSubscriptionEventPropagatesSubPlanFailure(t *testing.T) {
+	e := &Executor{}
+	event := map[string]interface{}{"id": "1"}
+
+	after := []*Plan{
+		{Service: "svc-a"},
+	}
+
+	if err := e.mergeSubscriptionEvent(context.Background(), after, event); err == nil {
+		t.Fatal("expected an error when the sub-plan's service is unknown")
+	}
+}
@@ -0,0 +1,195 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/samsarahq/thunder/graphql"
+)
+
+// HTTPExecutorClient is an ExecutorClient that sends queries to an
+// upstream federated service over HTTP. It honors ctx deadlines and
+// retries 5xx responses and network errors with exponential backoff.
+type HTTPExecutorClient struct {
+	URL    string
+	Client *http.Client
+
+	// MaxRetries is the number of additional attempts made after the
+	// first one fails. Defaults to 2 if zero.
+	MaxRetries int
+	// MinBackoff is the base delay before the first retry, doubled for
+	// each later retry and capped at MaxBackoff. Each delay is then
+	// jittered to a random value between half of it and all of it.
+	// Defaults to 50ms and 2s if zero.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewHTTPExecutorClient returns an HTTPExecutorClient posting to url using
+// rt as the transport. Passing a nil rt uses http.DefaultTransport, which
+// is the common case outside of tests and tracing integrations.
+func NewHTTPExecutorClient(url string, rt http.RoundTripper) *HTTPExecutorClient {
+	return &HTTPExecutorClient{
+		URL:    url,
+		Client: &http.Client{Transport: rt},
+	}
+}
+
+type httpGraphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName,omitempty"`
+}
+
+func (c *HTTPExecutorClient) maxRetries() int {
+	if c.MaxRetries > 0 {
+		return c.MaxRetries
+	}
+	return 2
+}
+
+func (c *HTTPExecutorClient) backoff(attempt int) time.Duration {
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min == 0 {
+		min = 50 * time.Millisecond
+	}
+	if max == 0 {
+		max = 2 * time.Second
+	}
+
+	d := min << uint(attempt)
+	if d <= 0 || d > max {
+		d = max
+	}
+	// jitter to avoid thundering herds against a recovering upstream.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2+1)))
+}
+
+// Execute implements ExecutorClient.
+func (c *HTTPExecutorClient) Execute(ctx context.Context, req *graphql.Query) ([]byte, error) {
+	return c.do(ctx, req)
+}
+
+func (c *HTTPExecutorClient) do(ctx context.Context, req *graphql.Query) ([]byte, error) {
+	payload, err := json.Marshal(httpGraphQLRequest{
+		Query:         req.Query,
+		Variables:     req.Variables,
+		OperationName: req.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %v", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries(); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.backoff(attempt - 1)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		body, status, err := c.post(ctx, payload)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if status >= 500 {
+			lastErr = fmt.Errorf("upstream %s returned status %d", c.URL, status)
+			continue
+		}
+
+		return body, nil
+	}
+
+	return nil, fmt.Errorf("executing request against %s: %v", c.URL, lastErr)
+}
+
+// Subscribe implements ExecutorClient. The response body is read as a
+// stream of JSON values (for example, one per line), each delivered as
+// one event. The request is sent once, without retries, and the
+// response's status code is not checked.
+func (c *HTTPExecutorClient) Subscribe(ctx context.Context, req *graphql.Query) (<-chan []byte, error) {
+	payload, err := json.Marshal(httpGraphQLRequest{
+		Query:         req.Query,
+		Variables:     req.Variables,
+		OperationName: req.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling subscription request: %v", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("building subscription request: %v", err)
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to %s: %v", c.URL, err)
+	}
+
+	out := make(chan []byte)
+	go func() {
+		defer resp.Body.Close()
+		defer close(out)
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return
+			}
+
+			select {
+			case out <- raw:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (c *HTTPExecutorClient) post(ctx context.Context, payload []byte) ([]byte, int, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, fmt.Errorf("building request: %v", err)
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("reading response: %v", err)
+	}
+
+	return body, resp.StatusCode, nil
+}
@@ -0,0 +1,128 @@
+package federation
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+)
+
+// CORSOptions configures the cross-origin allow-list applied to a
+// federated HTTP handler.
+type CORSOptions struct {
+	// AllowedOrigins is the list of origins allowed to make cross-origin
+	// requests. "*" allows any origin.
+	AllowedOrigins []string
+}
+
+// Timeouts bounds how long a federated HTTP server will wait on the
+// various phases of a connection, mirroring net/http.Server's own
+// knobs so operators can tune them per-endpoint.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Idle  time.Duration
+}
+
+// HTTPHandlerOptions configures RegisterHTTPHandler.
+//
+// The zero value is deliberately NOT fully open: an unset CORS rejects
+// every cross-origin request, while an empty VirtualHosts accepts any
+// Host header. Both defaulting open at once would let any page on the
+// internet read this gateway's responses from a browser, so only the
+// host check is permissive by default; set CORS explicitly (even to an
+// empty *CORSOptions{}, which still allows no origins) once the gateway
+// needs to serve browser clients from another origin.
+type HTTPHandlerOptions struct {
+	CORS *CORSOptions
+	// VirtualHosts restricts the Host header accepted by the handler.
+	// An empty list allows any host, which is only safe when the
+	// gateway is not reachable from the public internet.
+	VirtualHosts []string
+	Timeouts     Timeouts
+}
+
+func (o *HTTPHandlerOptions) allowedOrigin(origin string) bool {
+	// Unlike allowedHost's default-allow for an empty virtual-host list,
+	// an unconfigured CORS policy defaults to denying every cross-origin
+	// request. The two checks guard different things (which hostnames
+	// may reach this server at all, vs. which browser origins may read
+	// its responses) and defaulting both open at once would make a
+	// bare HTTPHandlerOptions{} readable by any page on the internet.
+	// Callers that do want an allow-list must set CORS explicitly.
+	if o.CORS == nil {
+		return false
+	}
+	for _, allowed := range o.CORS.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *HTTPHandlerOptions) allowedHost(host string) bool {
+	if len(o.VirtualHosts) == 0 {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, allowed := range o.VirtualHosts {
+		if allowed == host {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterHTTPHandler mounts stack at endpoint on mux, wrapping it with
+// the CORS and virtual-host checks from opts and applying opts.Timeouts
+// to the returned server. This is the server-side counterpart to
+// HTTPExecutorClient, analogous to how go-ethereum wires up its
+// GraphQL service behind a shared front door.
+func RegisterHTTPHandler(mux *http.ServeMux, endpoint string, stack http.Handler, opts HTTPHandlerOptions) *http.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !opts.allowedHost(r.Host) {
+			http.Error(w, "unknown host", http.StatusForbidden)
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !opts.allowedOrigin(origin) {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		stack.ServeHTTP(w, r)
+	})
+
+	mux.Handle(endpoint, handler)
+
+	return &http.Server{
+		Handler:      mux,
+		ReadTimeout:  opts.Timeouts.Read,
+		WriteTimeout: opts.Timeouts.Write,
+		IdleTimeout:  opts.Timeouts.Idle,
+	}
+}
+
+// writeJSONError writes status and message as a JSON body in the same
+// shape as a GraphQL error. RegisterHTTPHandler's own host and origin
+// rejections use plain-text http.Error instead.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
@@ -0,0 +1,39 @@
+package federation
+
+import (
+	"fmt"
+
+	"github.com/samsarahq/thunder/graphql"
+)
+
+// planSubscriptionRoot plans q the same way planRoot does, then enforces
+// the subscription-specific constraint that its root selection resolve
+// entirely on one upstream service: unlike a query or mutation root, a
+// subscription can't fan out across the gateway coordinator, since there
+// is no way to merge multiple long-lived upstream streams into one
+// coherent event sequence.
+func (p *Planner) planSubscriptionRoot(q *graphql.Query) (*Plan, error) {
+	root, err := p.planRoot(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return pinSubscriptionRoot(root)
+}
+
+// pinSubscriptionRoot collapses a gateway-coordinator root down to the
+// single service-level plan it fans out to, which is what Executor.Subscribe
+// actually opens the upstream stream against. It errors if planRoot produced
+// anything other than exactly one sub-plan, since that would mean the
+// subscription's root field spans more than one service.
+func pinSubscriptionRoot(root *Plan) (*Plan, error) {
+	if root.Service != gatewayCoordinatorServiceName {
+		return root, nil
+	}
+
+	if len(root.After) != 1 {
+		return nil, fmt.Errorf("subscription root must resolve on a single upstream service, got %d", len(root.After))
+	}
+
+	return root.After[0], nil
+}
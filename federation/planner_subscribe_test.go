@@ -0,0 +1,53 @@
+package federation
+
+import "testing"
+
+func TestPinSubscriptionRootSingleService(t *testing.T) {
+	leaf := &Plan{Service: "svc-a"}
+	root := &Plan{
+		Service: gatewayCoordinatorServiceName,
+		After:   []*Plan{leaf},
+	}
+
+	pinned, err := pinSubscriptionRoot(root)
+	if err != nil {
+		t.Fatalf("pinSubscriptionRoot: %v", err)
+	}
+	if pinned != leaf {
+		t.Fatalf("got plan for service %q, want the single sub-plan for %q", pinned.Service, leaf.Service)
+	}
+}
+
+func TestPinSubscriptionRootAlreadyPinned(t *testing.T) {
+	leaf := &Plan{Service: "svc-a"}
+
+	pinned, err := pinSubscriptionRoot(leaf)
+	if err != nil {
+		t.Fatalf("pinSubscriptionRoot: %v", err)
+	}
+	if pinned != leaf {
+		t.Fatalf("got %v, want the input plan unchanged", pinned)
+	}
+}
+
+func TestPinSubscriptionRootRejectsMultipleServices(t *testing.T) {
+	root := &Plan{
+		Service: gatewayCoordinatorServiceName,
+		After: []*Plan{
+			{Service: "svc-a"},
+			{Service: "svc-b"},
+		},
+	}
+
+	if _, err := pinSubscriptionRoot(root); err == nil {
+		t.Fatal("expected an error for a subscription root spanning multiple services")
+	}
+}
+
+func TestPinSubscriptionRootRejectsZeroServices(t *testing.T) {
+	root := &Plan{Service: gatewayCoordinatorServiceName}
+
+	if _, err := pinSubscriptionRoot(root); err == nil {
+		t.Fatal("expected an error for a subscription root with no sub-plan")
+	}
+}
@@ -0,0 +1,202 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReloadInProgress is returned by ReloadSchemas when another reload
+// (manual or watcher-triggered) is already in flight. Since a reload
+// re-fetches every upstream's schema, callers don't need to retry
+// immediately: the in-flight reload already covers whatever change
+// they were reacting to.
+var ErrReloadInProgress = errors.New("federation: schema reload already in progress")
+
+// SchemaDiff reports what changed between two introspections of the
+// federated schema, keyed by service so operators can tell which
+// upstream moved.
+type SchemaDiff struct {
+	AddedTypes   map[string][]string
+	RemovedTypes map[string][]string
+	ChangedTypes map[string][]string
+}
+
+func (d SchemaDiff) empty() bool {
+	return len(d.AddedTypes) == 0 && len(d.RemovedTypes) == 0 && len(d.ChangedTypes) == 0
+}
+
+// schemaWatcher periodically re-fetches one upstream's schema on behalf
+// of ReloadSchemas and reports back whether it changed since last seen.
+type schemaWatcher struct {
+	service  string
+	client   ExecutorClient
+	interval time.Duration
+}
+
+// WatchSchemas starts a goroutine per upstream in e.Executors that
+// re-runs introspection every interval and calls e.ReloadSchemas
+// whenever any of them reports a changed hash. Call the returned
+// stop func to end all watchers, e.g. on server shutdown.
+func (e *Executor) WatchSchemas(ctx context.Context, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	for service, client := range e.Executors {
+		w := &schemaWatcher{service: service, client: client, interval: interval}
+
+		go w.run(ctx, func() bool {
+			if _, err := e.ReloadSchemas(ctx); err != nil {
+				// Reload failures are transient (a bad deploy, a
+				// mid-rollout upstream, or another reload already in
+				// flight) and shouldn't take the gateway down; the
+				// next tick tries again.
+				return false
+			}
+			return true
+		})
+	}
+
+	return cancel
+}
+
+// run polls w's upstream every interval and calls onChange when its
+// schema hash differs from the last one recorded. The new hash is only
+// recorded once onChange reports success, so a failed reload is tried
+// again on the next tick.
+func (w *schemaWatcher) run(ctx context.Context, onChange func() bool) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var lastHash string
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			schema, err := fetchSchema(ctx, w.client)
+			if err != nil {
+				continue
+			}
+
+			hash := hashSchema(schema)
+			if lastHash != "" && hash != lastHash && !onChange() {
+				continue
+			}
+			lastHash = hash
+		}
+	}
+}
+
+func hashSchema(schema []byte) string {
+	sum := sha256.Sum256(schema)
+	return string(sum[:])
+}
+
+// ReloadSchemas re-runs introspection against every upstream, and if the
+// merged schema differs from the one currently in use, rebuilds the
+// types, flattener, and planner and swaps them in atomically so that any
+// Execute call already in flight keeps using the snapshot it started
+// with. It returns the computed diff, which is empty if nothing changed.
+//
+// ReloadSchemas single-flights: every caller, whether a WatchSchemas
+// goroutine or a manual trigger, goes through the same e.reloading CAS
+// guard, so a slow reload can never land after (and overwrite) a faster
+// one that started later. A caller that loses the race gets
+// ErrReloadInProgress rather than queuing behind the in-flight reload,
+// since that reload already covers every upstream.
+func (e *Executor) ReloadSchemas(ctx context.Context) (SchemaDiff, error) {
+	if !atomic.CompareAndSwapInt32(&e.reloading, 0, 1) {
+		return SchemaDiff{}, ErrReloadInProgress
+	}
+	defer atomic.StoreInt32(&e.reloading, 0)
+
+	planner, schemas, err := buildPlanner(ctx, e.Executors)
+	if err != nil {
+		return SchemaDiff{}, fmt.Errorf("reloading schemas: %v", err)
+	}
+
+	e.schemasMu.Lock()
+	diff := diffSchemas(e.lastSchemas, schemas)
+	if diff.empty() {
+		e.schemasMu.Unlock()
+		return diff, nil
+	}
+	e.lastSchemas = schemas
+	e.setPlanner(planner)
+	e.schemasMu.Unlock()
+
+	if e.OnSchemaChange != nil {
+		e.OnSchemaChange(diff)
+	}
+
+	return diff, nil
+}
+
+func diffSchemas(before, after map[string]*introspectionQueryResult) SchemaDiff {
+	diff := SchemaDiff{
+		AddedTypes:   map[string][]string{},
+		RemovedTypes: map[string][]string{},
+		ChangedTypes: map[string][]string{},
+	}
+
+	for service, afterSchema := range after {
+		beforeSchema, existed := before[service]
+		if !existed {
+			diff.AddedTypes[service] = typeNames(afterSchema)
+			continue
+		}
+
+		beforeTypes := typeSet(beforeSchema)
+		afterTypes := typeSet(afterSchema)
+
+		for name := range afterTypes {
+			if _, ok := beforeTypes[name]; !ok {
+				diff.AddedTypes[service] = append(diff.AddedTypes[service], name)
+			} else if !bytesEqualJSON(beforeTypes[name], afterTypes[name]) {
+				diff.ChangedTypes[service] = append(diff.ChangedTypes[service], name)
+			}
+		}
+		for name := range beforeTypes {
+			if _, ok := afterTypes[name]; !ok {
+				diff.RemovedTypes[service] = append(diff.RemovedTypes[service], name)
+			}
+		}
+	}
+
+	for service := range before {
+		if _, ok := after[service]; !ok {
+			diff.RemovedTypes[service] = typeNames(before[service])
+		}
+	}
+
+	return diff
+}
+
+func typeNames(schema *introspectionQueryResult) []string {
+	names := make([]string, 0, len(schema.Schema.Types))
+	for _, t := range schema.Schema.Types {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func typeSet(schema *introspectionQueryResult) map[string]json.RawMessage {
+	set := make(map[string]json.RawMessage, len(schema.Schema.Types))
+	for _, t := range schema.Schema.Types {
+		encoded, err := json.Marshal(t)
+		if err != nil {
+			continue
+		}
+		set[t.Name] = encoded
+	}
+	return set
+}
+
+func bytesEqualJSON(a, b json.RawMessage) bool {
+	return string(a) == string(b)
+}